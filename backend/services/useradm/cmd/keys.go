@@ -0,0 +1,67 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package cmd
+
+import (
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-server/services/useradm/jwt"
+)
+
+const (
+	flagKeyDir     = "key-dir"
+	flagKeyPattern = "key-pattern"
+	flagKeyKind    = "kind"
+)
+
+// KeysCommand exposes JWT signing key management as the `useradm keys` CLI
+// command.
+var KeysCommand = &cli.Command{
+	Name:  "keys",
+	Usage: "Manage useradm JWT signing keys",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "rotate",
+			Usage: "Generate a new signing key and make it the active one",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     flagKeyDir,
+					Required: true,
+					Usage:    "directory containing the signing keys",
+				},
+				&cli.StringFlag{
+					Name:     flagKeyPattern,
+					Required: true,
+					Usage:    "filename pattern for signing keys, e.g. private.key.%d.pem",
+				},
+				&cli.StringFlag{
+					Name:  flagKeyKind,
+					Value: "ed25519",
+					Usage: "key kind to generate: ed25519 or rsa",
+				},
+			},
+			Action: rotateKey,
+		},
+	},
+}
+
+func rotateKey(c *cli.Context) error {
+	ring, err := jwt.NewKeyRing(c.String(flagKeyDir), c.String(flagKeyPattern), 0)
+	if err != nil {
+		return err
+	}
+	defer ring.Close()
+
+	return ring.RotateNow(c.String(flagKeyKind))
+}
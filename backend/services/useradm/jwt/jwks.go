@@ -0,0 +1,220 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// JWK is the public portion of a signing key, RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 (OKP) / EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"` // EC only
+}
+
+// JWKSet is a JWK Set, RFC 7517 section 5.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKSHandler returns an http.HandlerFunc that serves the public half of
+// every private key currently loaded in kr as a JWK Set at
+// /.well-known/jwks.json, so that downstream services can verify
+// useradm-issued tokens without having the PEM files shipped to them out of
+// band. The set is recomputed from kr on every request, so a key rotation
+// picked up by kr's watcher is reflected immediately, including keys still
+// within their overlap window.
+func JWKSHandler(kr *KeyRing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.Marshal(kr.JWKS())
+		if err != nil {
+			http.Error(w, "failed to marshal JWKS", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}
+}
+
+// jwkFromPrivateKey derives the public JWK for kid from its private key.
+func jwkFromPrivateKey(key crypto.PrivateKey, kid int) (JWK, error) {
+	var pub crypto.PublicKey
+	switch v := key.(type) {
+	case *rsa.PrivateKey:
+		pub = &v.PublicKey
+	case ed25519.PrivateKey:
+		pub = v.Public()
+	case *ecdsa.PrivateKey:
+		pub = &v.PublicKey
+	default:
+		return JWK{}, errors.Errorf("unsupported server private key type")
+	}
+	return publicKeyToJWK(pub, kid)
+}
+
+func publicKeyToJWK(pub crypto.PublicKey, kid int) (JWK, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: strconv.Itoa(kid),
+			Use: "sig",
+			Alg: "RS256",
+			N:   jwkEncode(k.N.Bytes()),
+			E:   jwkEncode(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: strconv.Itoa(kid),
+			Use: "sig",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   jwkEncode(k),
+		}, nil
+	case *ecdsa.PublicKey:
+		crv, alg, size, err := ecdsaCurveParams(k.Curve)
+		if err != nil {
+			return JWK{}, err
+		}
+		return JWK{
+			Kty: "EC",
+			Kid: strconv.Itoa(kid),
+			Use: "sig",
+			Alg: alg,
+			Crv: crv,
+			X:   jwkEncode(padBigInt(k.X, size)),
+			Y:   jwkEncode(padBigInt(k.Y, size)),
+		}, nil
+	default:
+		return JWK{}, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// ecdsaCurveParams returns the JWK "crv" name, JWS "alg" and coordinate
+// byte length (RFC 7518 section 6.2.1) for curve.
+func ecdsaCurveParams(curve elliptic.Curve) (crv, alg string, size int, err error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", "ES256", 32, nil
+	case elliptic.P384():
+		return "P-384", "ES384", 48, nil
+	case elliptic.P521():
+		return "P-521", "ES512", 66, nil
+	default:
+		return "", "", 0, errors.Errorf("unsupported ECDSA curve %q", curve.Params().Name)
+	}
+}
+
+func padBigInt(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// PublicKey reconstructs the crypto.PublicKey described by j. It supports
+// the RSA, Ed25519 (OKP) and EC (P-256/P-384/P-521) key types this package
+// issues tokens with, which is also what external JWKS documents (e.g. an
+// OIDC provider's) typically contain for RS256/EdDSA/ES*-signed tokens.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := jwkDecode(j.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "n")
+		}
+		eBytes, err := jwkDecode(j.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "e")
+		}
+		e := 0
+		for _, b := range eBytes {
+			e = e<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: e}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported JWK curve %q", j.Crv)
+		}
+		x, err := jwkDecode(j.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "x")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid Ed25519 public key length %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported JWK curve %q", j.Crv)
+		}
+		x, err := jwkDecode(j.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "x")
+		}
+		y, err := jwkDecode(j.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "y")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported JWK key type %q", j.Kty)
+	}
+}
+
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
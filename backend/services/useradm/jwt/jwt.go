@@ -14,11 +14,18 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"math/big"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -50,10 +57,58 @@ type Handler interface {
 }
 
 func NewJWTHandler(privateKeyPath string, privateKeyFilenamePattern string) (Handler, error) {
-	priv, err := os.ReadFile(privateKeyPath)
-	block, _ := pem.Decode(priv)
+	kid := common.KeyIdFromPath(privateKeyPath, privateKeyFilenamePattern)
+	h, _, _, err := loadKeyHandler(privateKeyPath, kid)
+	return h, err
+}
+
+// loadKeyHandler reads, parses and wraps the private key at path into a
+// Handler for kid, also returning the JWS alg it signs with and the parsed
+// private key itself. The alg is used by KeyRing to cross-check an incoming
+// token's header, before its signature is verified, against the kid that is
+// about to be consulted, so a key of
+// one type can't be tricked into validating a token crafted for another
+// (see ErrTokenAlgMismatch). The private key is used by KeyRing to publish
+// its public half on the JWKS endpoint without re-reading and re-parsing
+// the file a second time.
+func loadKeyHandler(path string, kid int) (Handler, string, crypto.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "failed to read private key")
+	}
+	key, err := parsePrivateKeyPEM(raw)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	switch v := key.(type) {
+	case *rsa.PrivateKey:
+		return NewJWTHandlerRS256(v, kid), "RS256", v, nil
+	case ed25519.PrivateKey:
+		return NewJWTHandlerEd25519(&v, kid), "EdDSA", v, nil
+	case *ecdsa.PrivateKey:
+		switch v.Curve {
+		case elliptic.P256():
+			return NewJWTHandlerES256(v, kid), "ES256", v, nil
+		case elliptic.P384():
+			return NewJWTHandlerES384(v, kid), "ES384", v, nil
+		case elliptic.P521():
+			return NewJWTHandlerES512(v, kid), "ES512", v, nil
+		default:
+			return nil, "", nil, errors.Errorf("unsupported ECDSA curve %q", v.Curve.Params().Name)
+		}
+	}
+	return nil, "", nil, errors.Errorf("unsupported server private key type")
+}
+
+// parsePrivateKeyPEM decodes a PEM-encoded RSA (PKCS1/PKCS8), Ed25519
+// (PKCS8) or ECDSA (PKCS8) private key, returning the concrete key as
+// *rsa.PrivateKey, ed25519.PrivateKey or *ecdsa.PrivateKey. It is shared by
+// NewJWTHandler and the JWKS endpoint, which needs the public half of the
+// same key.
+func parsePrivateKeyPEM(raw []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
 	if block == nil {
-		return nil, errors.Wrap(err, "failed to read private key")
+		return nil, errors.New("failed to decode private key PEM")
 	}
 	switch block.Type {
 	case pemHeaderPKCS1:
@@ -61,54 +116,175 @@ func NewJWTHandler(privateKeyPath string, privateKeyFilenamePattern string) (Han
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read rsa private key")
 		}
-		return NewJWTHandlerRS256(
-				privKey,
-				common.KeyIdFromPath(privateKeyPath, privateKeyFilenamePattern),
-			),
-			nil
+		return privKey, nil
 	case pemHeaderPKCS8:
 		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read private key")
 		}
-		switch v := key.(type) {
-		case *rsa.PrivateKey:
-			return NewJWTHandlerRS256(
-					v,
-					common.KeyIdFromPath(privateKeyPath, privateKeyFilenamePattern),
-				),
-				nil
-		case ed25519.PrivateKey:
-			return NewJWTHandlerEd25519(
-					&v,
-					common.KeyIdFromPath(privateKeyPath, privateKeyFilenamePattern),
-				),
-				nil
+		switch key.(type) {
+		case *rsa.PrivateKey, ed25519.PrivateKey, *ecdsa.PrivateKey:
+			return key, nil
 		}
 	}
 	return nil, errors.Errorf("unsupported server private key type")
 }
 
-func GetKeyId(tokenString string) int {
-	token, _, err := jwtv4.NewParser().ParseUnverified(tokenString, &Claims{})
+// jwkPrivate is the subset of RFC 7518 JWK parameters needed to reconstruct
+// an RSA or Ed25519 (OKP) private key loaded via NewJWTHandlerFromJWK.
+type jwkPrivate struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
 
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	D string `json:"d"`
+	P string `json:"p"`
+	Q string `json:"q"`
+
+	// Ed25519 (OKP)
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// NewJWTHandlerFromJWK creates a Handler from a private key given as JWK or
+// JWK Set JSON (RFC 7517) rather than PEM, so operators can rotate keys by
+// dropping in JWK files. When given a JWK Set, the first key is used.
+func NewJWTHandlerFromJWK(jwkPath string) (Handler, error) {
+	raw, err := os.ReadFile(jwkPath)
 	if err != nil {
-		return common.KeyIdZero
+		return nil, errors.Wrap(err, "failed to read JWK file")
 	}
 
-	if _, ok := token.Header["kid"]; ok {
-		if _, ok := token.Header["kid"]; ok {
-			if _, isFloat := token.Header["kid"].(float64); isFloat {
-				return int(token.Header["kid"].(float64))
-			}
-			if _, isInt := token.Header["kid"].(int64); isInt {
-				return int(token.Header["kid"].(int64))
-			}
-			if _, isInt := token.Header["kid"].(int); isInt {
-				return token.Header["kid"].(int)
-			}
+	var jwk jwkPrivate
+	var set struct {
+		Keys []jwkPrivate `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err == nil && len(set.Keys) > 0 {
+		jwk = set.Keys[0]
+	} else if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, errors.Wrap(err, "failed to parse JWK")
+	}
+
+	kid := common.KeyIdZero
+	if n, err := strconv.Atoi(jwk.Kid); err == nil {
+		kid = n
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		priv, err := jwk.rsaPrivateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode RSA JWK")
+		}
+		return NewJWTHandlerRS256(priv, kid), nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported JWK curve %q", jwk.Crv)
+		}
+		priv, err := jwk.ed25519PrivateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode Ed25519 JWK")
 		}
+		return NewJWTHandlerEd25519(&priv, kid), nil
 	}
+	return nil, errors.Errorf("unsupported JWK key type %q", jwk.Kty)
+}
 
+func (j jwkPrivate) rsaPrivateKey() (*rsa.PrivateKey, error) {
+	n, err := jwkDecode(j.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "n")
+	}
+	eBytes, err := jwkDecode(j.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "e")
+	}
+	d, err := jwkDecode(j.D)
+	if err != nil {
+		return nil, errors.Wrap(err, "d")
+	}
+	p, err := jwkDecode(j.P)
+	if err != nil {
+		return nil, errors.Wrap(err, "p")
+	}
+	q, err := jwkDecode(j.Q)
+	if err != nil {
+		return nil, errors.Wrap(err, "q")
+	}
+
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: e,
+		},
+		D:      new(big.Int).SetBytes(d),
+		Primes: []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+	}
+	priv.Precompute()
+	if err := priv.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid RSA key parameters")
+	}
+	return priv, nil
+}
+
+func (j jwkPrivate) ed25519PrivateKey() (ed25519.PrivateKey, error) {
+	seed, err := jwkDecode(j.D)
+	if err != nil {
+		return nil, errors.Wrap(err, "d")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("invalid Ed25519 seed length %d", len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ErrTokenAlgMismatch is returned when a token's header alg does not match
+// the algorithm of the kid it claims to be signed with, e.g. an RS256 key's
+// kid on a token whose header alg is "HS256". A token whose header alg is
+// "none" never gets this far: GetKeyId returns no kid for it, and
+// KeyRing.FromJWT rejects it with ErrTokenInvalid. See KeyRing.FromJWT.
+var ErrTokenAlgMismatch = errors.New("jwt: token algorithm does not match key")
+
+// GetKeyId extracts the kid claimed by tokenString's header without
+// verifying its signature, for use as a lookup hint into a handler/key
+// ring map. It never returns a kid for a token whose header alg is "none":
+// that header is never produced by a legitimate signer, and treating it as
+// a valid lookup key would let an attacker pick which signing key gets
+// consulted purely from unverified header data.
+func GetKeyId(tokenString string) int {
+	token, _, err := jwtv4.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return common.KeyIdZero
+	}
+	if alg, _ := token.Header["alg"].(string); strings.EqualFold(alg, "none") {
+		return common.KeyIdZero
+	}
+
+	switch kid := token.Header["kid"].(type) {
+	case float64:
+		return int(kid)
+	case int64:
+		return int(kid)
+	case int:
+		return kid
+	}
 	return common.KeyIdZero
 }
+
+// tokenHeaderAlg extracts the alg claimed by tokenString's header without
+// verifying its signature.
+func tokenHeaderAlg(tokenString string) (string, bool) {
+	token, _, err := jwtv4.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return "", false
+	}
+	alg, ok := token.Header["alg"].(string)
+	return alg, ok
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-server/services/useradm/common"
+)
+
+// rawToken builds a JWT-shaped string from an arbitrary header/claims/
+// signature, bypassing any signing so tests can construct tokens a
+// legitimate Handler would never produce.
+func rawToken(t *testing.T, header, claims map[string]interface{}, signature string) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(h) + "." +
+		base64.RawURLEncoding.EncodeToString(c) + "." + signature
+}
+
+func TestGetKeyId(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header map[string]interface{}
+		want   int
+	}{
+		{
+			name:   "ok, numeric kid",
+			header: map[string]interface{}{"alg": "RS256", "kid": 3},
+			want:   3,
+		},
+		{
+			name:   "ok, no kid header",
+			header: map[string]interface{}{"alg": "RS256"},
+			want:   common.KeyIdZero,
+		},
+		{
+			name:   "alg none, unsigned-token bypass attempt",
+			header: map[string]interface{}{"alg": "none", "kid": 3},
+			want:   common.KeyIdZero,
+		},
+		{
+			name:   "alg None, mixed-case unsigned-token bypass attempt",
+			header: map[string]interface{}{"alg": "None", "kid": 3},
+			want:   common.KeyIdZero,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := rawToken(t, tc.header, map[string]interface{}{"sub": "1"}, "")
+			assert.Equal(t, tc.want, GetKeyId(token))
+		})
+	}
+}
+
+// TestKeyRingFromJWTRejectsAlgConfusion covers the classic
+// algorithm-substitution attack (an RS256 token re-signed as HS256 with the
+// RSA public key as the HMAC secret) and the unsigned-token bypass (header
+// alg "none"), both of which must be rejected before a key's Handler ever
+// sees the token.
+func TestKeyRingFromJWTRejectsAlgConfusion(t *testing.T) {
+	dir := t.TempDir()
+	pattern := "private.key.%d.pem"
+	keyPath := filepath.Join(dir, fmt.Sprintf(pattern, 1))
+	require.NoError(t, GenerateKeyFile(keyPath, "rsa"))
+
+	ring, err := NewKeyRing(dir, pattern, time.Minute)
+	require.NoError(t, err)
+	defer ring.Close()
+
+	pemBytes, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	block, _ := pem.Decode(pemBytes)
+	require.NotNil(t, block)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	require.True(t, ok)
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		token   func() string
+		wantErr error
+	}{
+		{
+			name: "algorithm confusion: RS256 key re-used as HS256 secret",
+			token: func() string {
+				forged := jwtv4.NewWithClaims(jwtv4.SigningMethodHS256, jwtv4.MapClaims{
+					"sub": "attacker",
+					"exp": time.Now().Add(time.Hour).Unix(),
+				})
+				forged.Header["kid"] = 1
+				s, err := forged.SignedString(pubDER)
+				require.NoError(t, err)
+				return s
+			},
+			wantErr: ErrTokenAlgMismatch,
+		},
+		{
+			name: "unsigned token claiming a real kid",
+			token: func() string {
+				return rawToken(t,
+					map[string]interface{}{"alg": "none", "kid": 1, "typ": "JWT"},
+					map[string]interface{}{"sub": "attacker"},
+					"",
+				)
+			},
+			// GetKeyId maps alg "none" to KeyIdZero, which isn't in the
+			// ring, so this is rejected before the alg check ever runs.
+			wantErr: ErrTokenInvalid,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ring.FromJWT(tc.token())
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
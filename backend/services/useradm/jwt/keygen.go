@@ -0,0 +1,61 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// rsaKeyBits is the modulus size used for generated RSA signing keys.
+const rsaKeyBits = 2048
+
+// GenerateKeyFile creates a new signing key of the given kind ("ed25519" or
+// "rsa") and writes it PEM/PKCS8-encoded to path, for use by RotateNow or
+// the `useradm keys rotate` CLI command.
+func GenerateKeyFile(path, kind string) error {
+	var (
+		key interface{}
+		err error
+	)
+	switch kind {
+	case "ed25519":
+		_, priv, genErr := ed25519.GenerateKey(rand.Reader)
+		if genErr != nil {
+			return errors.Wrap(genErr, "failed to generate ed25519 key")
+		}
+		key = priv
+	case "rsa":
+		priv, genErr := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if genErr != nil {
+			return errors.Wrap(genErr, "failed to generate rsa key")
+		}
+		key = priv
+	default:
+		return errors.Errorf("unsupported key kind %q", kind)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal private key")
+	}
+	block := &pem.Block{Type: pemHeaderPKCS8, Bytes: der}
+	return os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package jwt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mendersoftware/mender-server/services/useradm/common"
+)
+
+var (
+	keyRingSignsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "useradm",
+		Subsystem: "jwt",
+		Name:      "signs_total",
+		Help:      "Number of JWTs signed, labeled by signing key id.",
+	}, []string{"kid"})
+	keyRingVerifiesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "useradm",
+		Subsystem: "jwt",
+		Name:      "verifies_total",
+		Help:      "Number of JWTs verified, labeled by the key id that verified them.",
+	}, []string{"kid"})
+)
+
+// KeyRing watches a directory of signing keys and hot-reloads the Handler
+// used for each kid without requiring a process restart. New tokens are
+// always signed with the newest kid; a kid that stops being the newest
+// remains valid for verification only until it has been retired for longer
+// than the configured overlap window, a check FromJWT makes at verify time.
+// reload() itself never prunes a kid on a timer — it only forgets a kid's
+// retirement once that kid's key file is removed from disk. KeyRing itself
+// implements Handler so it is a drop-in replacement for the single Handler
+// previously stored in UserAdmApiHandlers.jwth.
+type KeyRing struct {
+	dir     string
+	pattern string
+	overlap time.Duration
+
+	mu        sync.RWMutex
+	handlers  map[int]Handler
+	algs      map[int]string
+	keys      map[int]JWK
+	retiredAt map[int]time.Time
+	newest    int
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// NewKeyRing loads every private key matching pattern in dir and starts a
+// background watcher that reloads the ring whenever the directory changes.
+// overlap controls how long a key that has been superseded by a newer one
+// remains valid for verification.
+func NewKeyRing(dir, pattern string, overlap time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{
+		dir:       dir,
+		pattern:   pattern,
+		overlap:   overlap,
+		retiredAt: make(map[int]time.Time),
+		done:      make(chan struct{}),
+	}
+	if err := kr.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start key directory watcher")
+	}
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return nil, errors.Wrap(err, "failed to watch key directory")
+	}
+	kr.watcher = watcher
+	go kr.watch()
+	return kr, nil
+}
+
+func (kr *KeyRing) watch() {
+	for {
+		select {
+		case event, ok := <-kr.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
+				_ = kr.reload()
+			}
+		case <-kr.watcher.Errors:
+			// best-effort: keep watching, the next successful event will
+			// still trigger a reload
+		case <-kr.done:
+			return
+		}
+	}
+}
+
+// Close stops the background watcher. The KeyRing must not be used
+// afterwards.
+func (kr *KeyRing) Close() error {
+	close(kr.done)
+	if kr.watcher != nil {
+		return kr.watcher.Close()
+	}
+	return nil
+}
+
+// reload re-scans the key directory and atomically swaps in the new handler
+// map, marking the previous newest kid as retired if it was replaced.
+// retiredAt is otherwise left untouched here: whether a retired kid is still
+// within its overlap window is decided at verify time in FromJWT, not by
+// pruning it out of the handler map on a timer, since the next fs event
+// (e.g. an unrelated key being added) would just reload it straight back
+// off disk and reset the clock on its retirement.
+func (kr *KeyRing) reload() error {
+	entries, err := os.ReadDir(kr.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list key directory")
+	}
+
+	handlers := make(map[int]Handler)
+	algs := make(map[int]string)
+	keys := make(map[int]JWK)
+	var kids []int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(kr.dir, entry.Name())
+		kid := common.KeyIdFromPath(path, kr.pattern)
+		if kid == common.KeyIdZero {
+			continue
+		}
+		h, alg, priv, err := loadKeyHandler(path, kid)
+		if err != nil {
+			continue
+		}
+		jwk, err := jwkFromPrivateKey(priv, kid)
+		if err != nil {
+			continue
+		}
+		handlers[kid] = h
+		algs[kid] = alg
+		keys[kid] = jwk
+		kids = append(kids, kid)
+	}
+	if len(kids) == 0 {
+		return errors.New("no private keys found in key directory")
+	}
+	sort.Ints(kids)
+	newest := kids[len(kids)-1]
+
+	now := time.Now()
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kr.newest == common.KeyIdZero {
+		// First load (e.g. right after a process restart): any non-newest
+		// kid already on disk was retired at some unknown point before this
+		// process started. Start its overlap clock now rather than treating
+		// it as never retired, so a token signed with it moments before the
+		// restart doesn't stop verifying the instant the process comes back.
+		for _, kid := range kids {
+			if kid != newest {
+				kr.retiredAt[kid] = now
+			}
+		}
+	} else if kr.newest != newest {
+		kr.retiredAt[kr.newest] = now
+	}
+	// A retired kid is only forgotten once its key file is actually gone
+	// from disk; it is never un-retired just because reload() ran again.
+	for kid := range kr.retiredAt {
+		if _, ok := handlers[kid]; !ok {
+			delete(kr.retiredAt, kid)
+		}
+	}
+	kr.handlers = handlers
+	kr.algs = algs
+	kr.keys = keys
+	kr.newest = newest
+	return nil
+}
+
+// JWKS returns the public JWK Set for every key currently loaded in the
+// ring, including any kid still within its overlap window, so that a
+// downstream service serving stale-cached JWKS has time to pick up a
+// rotation before the old key stops verifying tokens it already accepted.
+func (kr *KeyRing) JWKS() JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	set := JWKSet{Keys: make([]JWK, 0, len(kr.keys))}
+	kids := make([]int, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Ints(kids)
+	for _, kid := range kids {
+		set.Keys = append(set.Keys, kr.keys[kid])
+	}
+	return set
+}
+
+// ToJWT signs t with the newest key in the ring.
+func (kr *KeyRing) ToJWT(t *Token) (string, error) {
+	kr.mu.RLock()
+	h, ok := kr.handlers[kr.newest]
+	kid := kr.newest
+	kr.mu.RUnlock()
+	if !ok {
+		return "", errors.New("jwt: no signing key loaded")
+	}
+	token, err := h.ToJWT(t)
+	if err == nil {
+		keyRingSignsTotal.WithLabelValues(strconv.Itoa(kid)).Inc()
+	}
+	return token, err
+}
+
+// FromJWT verifies tokenString against whichever key in the ring matches
+// its kid, including a kid that has been retired (superseded by a newer
+// one) as long as it is still within the configured overlap window. Before
+// delegating to that key's Handler it cross-checks the token header's alg
+// against the alg the kid actually signs with, so a token that claims an
+// RS256/Ed25519/ECDSA kid but is itself HS256-signed is rejected with
+// ErrTokenAlgMismatch rather than reaching the underlying signature check
+// with an attacker-chosen algorithm. An unsigned token (alg "none") yields
+// no kid from GetKeyId and is rejected with ErrTokenInvalid.
+func (kr *KeyRing) FromJWT(tokenString string) (*Token, error) {
+	kid := GetKeyId(tokenString)
+	kr.mu.RLock()
+	h, ok := kr.handlers[kid]
+	alg := kr.algs[kid]
+	newest := kr.newest
+	retiredAt, retired := kr.retiredAt[kid]
+	kr.mu.RUnlock()
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	if kid != newest {
+		if !retired || time.Since(retiredAt) > kr.overlap {
+			return nil, ErrTokenInvalid
+		}
+	}
+	if headerAlg, ok := tokenHeaderAlg(tokenString); !ok || !strings.EqualFold(headerAlg, alg) {
+		return nil, ErrTokenAlgMismatch
+	}
+	token, err := h.FromJWT(tokenString)
+	if err == nil {
+		keyRingVerifiesTotal.WithLabelValues(strconv.Itoa(kid)).Inc()
+	}
+	return token, err
+}
+
+// RotateNow generates a new key of the given kind ("ed25519" or "rsa"),
+// writes it to the key directory under the next sequential kid, and
+// reloads the ring immediately rather than waiting for the watcher to pick
+// it up.
+func (kr *KeyRing) RotateNow(kind string) error {
+	kr.mu.RLock()
+	nextKid := kr.newest + 1
+	kr.mu.RUnlock()
+
+	path := filepath.Join(kr.dir, fmt.Sprintf(kr.pattern, nextKid))
+	if err := GenerateKeyFile(path, kind); err != nil {
+		return err
+	}
+	return kr.reload()
+}
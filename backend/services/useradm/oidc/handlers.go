@@ -0,0 +1,292 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-server/services/useradm/jwt"
+)
+
+const (
+	// oidcStateCookie carries the signed, short-lived PKCE/state/nonce
+	// bundle between the login and callback requests.
+	oidcStateCookie = "useradm-oidc-state"
+	oidcStateTTL    = 5 * time.Minute
+)
+
+// authRequestState is the data LoginHandler needs CallbackHandler to see
+// again; it is HMAC-signed and round-tripped through the client as a
+// cookie rather than kept server-side, consistent with the rest of
+// useradm being stateless.
+type authRequestState struct {
+	Provider     string    `json:"provider"`
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+// ApiHandlers implements the OIDC relying-party endpoints:
+//
+//	GET /api/management/v1/useradm/oidc/{provider}/login
+//	GET /api/management/v1/useradm/oidc/{provider}/callback
+type ApiHandlers struct {
+	providers map[string]*Provider
+	users     UserStore
+	jwth      jwt.Handler
+	secret    []byte
+}
+
+// NewApiHandlers constructs the OIDC handlers for a tenant's configured
+// providers. secret signs the state cookie used to carry the PKCE verifier
+// and nonce across the redirect to the IdP and back.
+func NewApiHandlers(cfg TenantConfig, users UserStore, jwth jwt.Handler, secret []byte) (*ApiHandlers, error) {
+	providers := make(map[string]*Provider, len(cfg.Providers))
+	for name, pcfg := range cfg.Providers {
+		p, err := NewProvider(name, pcfg, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize OIDC provider %q", name)
+		}
+		providers[name] = p
+	}
+	return &ApiHandlers{providers: providers, users: users, jwth: jwth, secret: secret}, nil
+}
+
+// providerFromRequest resolves the {provider} path parameter without
+// depending on a particular router: it is always the path segment
+// immediately before the trailing "login"/"callback" segment.
+func (a *ApiHandlers) providerFromRequest(r *http.Request) (*Provider, bool) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	segments := strings.Split(path, "/")
+	if len(segments) < 2 {
+		return nil, false
+	}
+	p, ok := a.providers[segments[len(segments)-2]]
+	return p, ok
+}
+
+// LoginHandler starts the authorization-code + PKCE flow by redirecting the
+// user to the provider's authorization endpoint.
+func (a *ApiHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.providerFromRequest(r)
+	if !ok {
+		http.Error(w, "oidc: unknown provider", http.StatusNotFound)
+		return
+	}
+
+	codeVerifier, codeChallenge, err := generatePKCE()
+	if err != nil {
+		http.Error(w, "oidc: failed to start login", http.StatusInternalServerError)
+		return
+	}
+	state, err := randomString(32)
+	if err != nil {
+		http.Error(w, "oidc: failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		http.Error(w, "oidc: failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := a.signState(authRequestState{
+		Provider:     provider.name,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		IssuedAt:     time.Now(),
+	})
+	if err != nil {
+		http.Error(w, "oidc: failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.buildAuthURL(state, nonce, codeChallenge), http.StatusFound)
+}
+
+// CallbackHandler completes the flow: it exchanges the authorization code
+// for an ID token, verifies it, maps the resulting identity to a useradm
+// user, and mints a useradm JWT for it.
+func (a *ApiHandlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.providerFromRequest(r)
+	if !ok {
+		http.Error(w, "oidc: unknown provider", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "oidc: missing state cookie", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/", MaxAge: -1})
+
+	st, err := a.parseState(cookie.Value)
+	if err != nil || st.Provider != provider.name || time.Since(st.IssuedAt) > oidcStateTTL {
+		http.Error(w, "oidc: invalid or expired state", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("state") != st.State {
+		http.Error(w, "oidc: state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "oidc: missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	rawIDToken, err := provider.exchangeCode(r.Context(), code, st.CodeVerifier)
+	if err != nil {
+		http.Error(w, "oidc: failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+
+	claims, err := provider.verifyIDToken(rawIDToken)
+	if err != nil {
+		http.Error(w, "oidc: failed to verify ID token", http.StatusUnauthorized)
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != st.Nonce {
+		http.Error(w, "oidc: nonce mismatch", http.StatusUnauthorized)
+		return
+	}
+
+	subject, _ := claims[provider.subjectClaim()].(string)
+	if subject == "" {
+		http.Error(w, "oidc: ID token missing subject claim", http.StatusUnauthorized)
+		return
+	}
+	issuer, _ := claims["iss"].(string)
+
+	user, err := a.users.GetUserByOIDCIdentity(r.Context(), issuer, subject)
+	if errors.Cause(err) == ErrUserNotFound {
+		if !provider.cfg.AutoProvision {
+			http.Error(w, "oidc: user not provisioned", http.StatusForbidden)
+			return
+		}
+		user = &User{
+			Email:   stringClaim(claims, "email"),
+			Role:    stringClaim(claims, provider.cfg.RoleClaim),
+			Issuer:  issuer,
+			Subject: subject,
+		}
+		if err := a.users.CreateUserFromOIDC(r.Context(), user); err != nil {
+			http.Error(w, "oidc: failed to provision user", http.StatusInternalServerError)
+			return
+		}
+	} else if err != nil {
+		http.Error(w, "oidc: failed to look up user", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := a.jwth.ToJWT(&jwt.Token{
+		Claims: jwt.Claims{
+			Subject: user.ID,
+		},
+	})
+	if err != nil {
+		http.Error(w, "oidc: failed to mint token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	if name == "" {
+		return ""
+	}
+	s, _ := claims[name].(string)
+	return s
+}
+
+func generatePKCE() (verifier, challenge string, err error) {
+	verifier, err = randomString(64)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "oidc: failed to generate random value")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// signState JSON-encodes st and appends an HMAC-SHA256 signature so it can
+// be safely round-tripped through a client-held cookie.
+func (a *ApiHandlers) signState(st authRequestState) (string, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (a *ApiHandlers) parseState(cookie string) (authRequestState, error) {
+	parts := strings.SplitN(cookie, ".", 2)
+	if len(parts) != 2 {
+		return authRequestState{}, errors.New("oidc: malformed state cookie")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return authRequestState{}, errors.Wrap(err, "oidc: malformed state cookie")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return authRequestState{}, errors.Wrap(err, "oidc: malformed state cookie")
+	}
+
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return authRequestState{}, errors.New("oidc: invalid state signature")
+	}
+
+	var st authRequestState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return authRequestState{}, errors.Wrap(err, "oidc: malformed state cookie")
+	}
+	return st, nil
+}
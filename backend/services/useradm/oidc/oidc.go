@@ -0,0 +1,78 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+// Package oidc implements a relying party that lets useradm users
+// authenticate via an external OIDC identity provider (Google, Azure AD,
+// Keycloak, etc.) using the authorization-code flow with PKCE, and receive
+// a useradm-signed JWT in exchange.
+package oidc
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUserNotFound is returned by UserStore.GetUserByOIDCIdentity when no
+// useradm user is mapped to the given issuer/subject pair.
+var ErrUserNotFound = errors.New("oidc: user not found")
+
+// ProviderConfig configures a single external identity provider that users
+// can authenticate with via /oidc/{provider}/login. It is loaded per tenant
+// from the useradm config file.
+type ProviderConfig struct {
+	// IssuerURL is the OIDC issuer; discovery is fetched from
+	// IssuerURL + "/.well-known/openid-configuration".
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+
+	// SubjectClaim is the ID token claim used, together with the
+	// issuer, to identify the user. Defaults to "sub"; set it to e.g.
+	// "email" for providers where that is the stable identifier.
+	SubjectClaim string `json:"subject_claim"`
+	// RoleClaim, if set, names the ID token claim mapped to a useradm
+	// role when auto-provisioning a user.
+	RoleClaim string `json:"role_claim"`
+	// AutoProvision creates a useradm user on first successful login
+	// instead of requiring one to already exist.
+	AutoProvision bool `json:"auto_provision"`
+}
+
+// TenantConfig is the OIDC configuration for a single tenant: the set of
+// providers its users may authenticate against, keyed by the provider name
+// used in the login/callback routes.
+type TenantConfig struct {
+	Providers map[string]ProviderConfig `json:"providers"`
+}
+
+// User is the minimal useradm user representation the OIDC flow needs in
+// order to mint a token, either for an existing user or one being
+// auto-provisioned.
+type User struct {
+	ID      string
+	Email   string
+	Role    string
+	Issuer  string
+	Subject string
+}
+
+// UserStore is the subset of the useradm datastore the OIDC handlers need
+// to map an external identity to a useradm user.
+type UserStore interface {
+	GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	CreateUserFromOIDC(ctx context.Context, user *User) error
+}
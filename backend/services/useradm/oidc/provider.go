@@ -0,0 +1,250 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/pquerna/cachecontrol"
+
+	"github.com/mendersoftware/mender-server/services/useradm/jwt"
+)
+
+// defaultJWKSCacheTTL is used when the provider's JWKS response carries no
+// usable cache-control/expires information.
+const defaultJWKSCacheTTL = 15 * time.Minute
+
+// discoveryDocument is the subset of RFC 8414 / OIDC discovery metadata
+// this package needs.
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a single configured external identity provider: its
+// discovery metadata and a cache-control-aware JWKS cache used to verify
+// the ID tokens it issues.
+type Provider struct {
+	name  string
+	cfg   ProviderConfig
+	httpc *http.Client
+
+	discovery discoveryDocument
+
+	mu            sync.RWMutex
+	keySet        jwt.JWKSet
+	jwksExpiresAt time.Time
+}
+
+// NewProvider fetches name's discovery document and returns a ready-to-use
+// Provider. httpc may be nil, in which case http.DefaultClient is used.
+func NewProvider(name string, cfg ProviderConfig, httpc *http.Client) (*Provider, error) {
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+	p := &Provider{name: name, cfg: cfg, httpc: httpc}
+
+	resp, err := httpc.Get(strings.TrimSuffix(cfg.IssuerURL, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, errors.Wrapf(err, "oidc: failed to fetch discovery document for %q", name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("oidc: discovery document for %q returned %s", name, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&p.discovery); err != nil {
+		return nil, errors.Wrapf(err, "oidc: failed to parse discovery document for %q", name)
+	}
+	return p, nil
+}
+
+// subjectClaim is the ID token claim used to identify the user, defaulting
+// to "sub".
+func (p *Provider) subjectClaim() string {
+	if p.cfg.SubjectClaim != "" {
+		return p.cfg.SubjectClaim
+	}
+	return "sub"
+}
+
+// buildAuthURL returns the authorization endpoint URL to redirect the user
+// to in order to start the authorization-code + PKCE flow.
+func (p *Provider) buildAuthURL(state, nonce, codeChallenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+	return p.discovery.AuthorizationEndpoint + "?" + v.Encode()
+}
+
+// exchangeCode exchanges an authorization code for an ID token, presenting
+// codeVerifier so the IdP can validate the PKCE challenge sent at login.
+func (p *Provider) exchangeCode(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "oidc: failed to build token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(p.cfg.ClientID, p.cfg.ClientSecret)
+	}
+
+	resp, err := p.httpc.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "oidc: failed to call token endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("oidc: token endpoint returned %s", resp.Status)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", errors.Wrap(err, "oidc: failed to decode token response")
+	}
+	if tokenResp.IDToken == "" {
+		return "", errors.New("oidc: token response missing id_token")
+	}
+	return tokenResp.IDToken, nil
+}
+
+// jwks returns the provider's current JWK Set, re-fetching it once the
+// previous response's cache-control/expires lifetime (per pquerna/cachecontrol)
+// has elapsed.
+func (p *Provider) jwks() (jwt.JWKSet, error) {
+	p.mu.RLock()
+	if time.Now().Before(p.jwksExpiresAt) {
+		set := p.keySet
+		p.mu.RUnlock()
+		return set, nil
+	}
+	p.mu.RUnlock()
+
+	req, err := http.NewRequest(http.MethodGet, p.discovery.JWKSURI, nil)
+	if err != nil {
+		return jwt.JWKSet{}, errors.Wrap(err, "oidc: failed to build JWKS request")
+	}
+	resp, err := p.httpc.Do(req)
+	if err != nil {
+		return jwt.JWKSet{}, errors.Wrap(err, "oidc: failed to fetch JWKS")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return jwt.JWKSet{}, errors.Wrap(err, "oidc: failed to read JWKS response")
+	}
+	var set jwt.JWKSet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return jwt.JWKSet{}, errors.Wrap(err, "oidc: failed to parse JWKS")
+	}
+
+	ttl := defaultJWKSCacheTTL
+	if reasons, expires, err := cachecontrol.CachableResponse(req, resp, cachecontrol.Options{}); err == nil &&
+		len(reasons) == 0 && !expires.IsZero() {
+		if d := time.Until(expires); d > 0 {
+			ttl = d
+		}
+	}
+
+	p.mu.Lock()
+	p.keySet = set
+	p.jwksExpiresAt = time.Now().Add(ttl)
+	p.mu.Unlock()
+	return set, nil
+}
+
+// verifyIDToken checks rawIDToken's signature against the provider's JWKS
+// and that its issuer matches the provider's discovered issuer, returning
+// its claims.
+func (p *Provider) verifyIDToken(rawIDToken string) (jwtv4.MapClaims, error) {
+	keySet, err := p.jwks()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwtv4.MapClaims{}
+	parser := jwtv4.NewParser(jwtv4.WithValidMethods(
+		[]string{"RS256", "RS384", "RS512", "EdDSA", "ES256", "ES384", "ES512"},
+	))
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(t *jwtv4.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, k := range keySet.Keys {
+			if k.Kid == kid {
+				return k.PublicKey()
+			}
+		}
+		return nil, errors.Errorf("oidc: unknown signing key %q", kid)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc: failed to verify ID token")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.discovery.Issuer {
+		return nil, errors.Errorf("oidc: unexpected issuer %q", iss)
+	}
+	// jwtv4.MapClaims.Valid() does not check aud, so without this an ID
+	// token the IdP minted for a different client at the same issuer
+	// would be accepted and exchanged for a useradm JWT.
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, errors.Errorf("oidc: token audience does not include client %q", p.cfg.ClientID)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oidc: ID token missing exp claim")
+	}
+	return claims, nil
+}
+
+// audienceContains reports whether aud (the raw "aud" claim, either a
+// single string or a list per RFC 7519 section 4.1.3) contains clientID.
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, entry := range v {
+			if s, ok := entry.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package useradm
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/mendersoftware/mender-server/services/useradm/cmd"
+	"github.com/mendersoftware/mender-server/services/useradm/jwt"
+	"github.com/mendersoftware/mender-server/services/useradm/oidc"
+)
+
+// UserAdmApiHandlers wires the jwt/oidc/cmd subsystems into the useradm
+// HTTP API and CLI. jwth is a *jwt.KeyRing rather than a bare jwt.Handler so
+// that hot key rotation and the alg-confusion hardening in
+// jwt.KeyRing.FromJWT apply to every handler below, including
+// AuthLogoutHandler, instead of only to callers that happen to reach for
+// KeyRing directly.
+type UserAdmApiHandlers struct {
+	jwth *jwt.KeyRing
+	oidc *oidc.ApiHandlers
+}
+
+// NewUserAdmApiHandlers constructs the handlers from jwth and oidcHandlers.
+// oidcHandlers mints the JWTs returned from a successful OIDC login with the
+// jwt.Handler it was built with; this constructor does not pass jwth to it.
+// oidcHandlers may be nil if no OIDC providers are configured.
+func NewUserAdmApiHandlers(jwth *jwt.KeyRing, oidcHandlers *oidc.ApiHandlers) *UserAdmApiHandlers {
+	return &UserAdmApiHandlers{jwth: jwth, oidc: oidcHandlers}
+}
+
+// AuthLogoutHandler verifies the bearer token presented by the client
+// through a.jwth and replies 204 No Content if it is valid; the token is not
+// revoked or recorded anywhere. It must not maintain its own
+// key/handler lookup: doing so previously let a logout request bypass the
+// alg cross-check KeyRing.FromJWT performs, which is exactly the check
+// ErrTokenAlgMismatch exists to enforce.
+func (a *UserAdmApiHandlers) AuthLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	if _, err := a.jwth.FromJWT(tokenString); err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bearerToken extracts the token from a "Bearer <token>" Authorization
+// header, returning "" if the header is missing or malformed.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return h[len(prefix):]
+}
+
+// RegisterRoutes wires the JWKS endpoint, auth routes and, if configured,
+// the OIDC login/callback routes onto mux.
+func (a *UserAdmApiHandlers) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/.well-known/jwks.json", jwt.JWKSHandler(a.jwth))
+	mux.HandleFunc("/api/management/v1/useradm/auth/logout", a.AuthLogoutHandler)
+	if a.oidc == nil {
+		return
+	}
+	mux.HandleFunc("/api/management/v1/useradm/oidc/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/callback") {
+			a.oidc.CallbackHandler(w, r)
+			return
+		}
+		a.oidc.LoginHandler(w, r)
+	})
+}
+
+// Commands returns the useradm-specific CLI subcommands, e.g.
+// `useradm keys rotate`, for registration into the service's cli.App.
+func Commands() []*cli.Command {
+	return []*cli.Command{cmd.KeysCommand}
+}